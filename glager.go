@@ -5,14 +5,24 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"regexp"
 
+	"github.com/onsi/gomega"
 	"github.com/onsi/gomega/format"
 	"github.com/onsi/gomega/gbytes"
 	"github.com/onsi/gomega/types"
-	"github.com/pivotal-golang/lager"
+
+	"code.cloudfoundry.org/lager/v3"
 )
 
-type logEntry lager.LogFormat
+type logEntry struct {
+	lager.LogFormat
+	message          fieldMatcher
+	source           fieldMatcher
+	dataMatchers     map[string]fieldMatcher
+	absentDataKeys   []string
+	absentDataValues map[string]fieldMatcher
+}
 
 type logEntries []logEntry
 
@@ -20,6 +30,51 @@ type logEntryData lager.Data
 
 type option func(*logEntry)
 
+// fieldMatcher is the small interface logEntry.contains dispatches on to
+// compare an expected field against its actual counterpart. equalMatcher
+// backs the literal Message/Source/Data options, regexpMatcher backs
+// MatchMessage/MatchSource and gomegaMatcher backs MatchData.
+type fieldMatcher interface {
+	match(actual interface{}) (bool, error)
+}
+
+type equalMatcher struct {
+	expected interface{}
+}
+
+func (m equalMatcher) match(actual interface{}) (bool, error) {
+	// this has been marshalled and unmarshalled before, no need to check err
+	actualJSON, _ := json.Marshal(actual)
+
+	expectedJSON, err := json.Marshal(m.expected)
+	if err != nil {
+		return false, err
+	}
+
+	return string(actualJSON) == string(expectedJSON), nil
+}
+
+type regexpMatcher struct {
+	re *regexp.Regexp
+}
+
+func (m regexpMatcher) match(actual interface{}) (bool, error) {
+	s, ok := actual.(string)
+	if !ok {
+		return false, fmt.Errorf("Invalid type for regexp match. Want string. Got %T:%v.", actual, actual)
+	}
+
+	return m.re.MatchString(s), nil
+}
+
+type gomegaMatcher struct {
+	matcher types.GomegaMatcher
+}
+
+func (m gomegaMatcher) match(actual interface{}) (bool, error) {
+	return m.matcher.Match(actual)
+}
+
 type TestLogger struct {
 	lager.Logger
 	buf *gbytes.Buffer
@@ -36,9 +91,48 @@ func (l *TestLogger) Buffer() *gbytes.Buffer {
 	return l.buf
 }
 
+// Reader returns a thread-safe, append-only io.Reader view of the entries
+// logged so far. It is safe to read from while the logger is concurrently
+// written to from other goroutines.
+func (l *TestLogger) Reader() io.Reader {
+	return l.buf
+}
+
+// RecoverFatal invokes fn, which is expected to call logger.Fatal and
+// thereby panic, recovers from that panic and returns logger's buffer so
+// callers can assert on Fatal's output with the usual glager matchers,
+// without having to wrap every call site in its own deferred recover.
+func RecoverFatal(logger *TestLogger, fn func()) (buf *gbytes.Buffer) {
+	defer func() {
+		recover()
+		buf = logger.Buffer()
+	}()
+
+	fn()
+
+	return
+}
+
+// sequenceMode selects how a logMatcher relates its expected entries to the
+// actual log stream.
+type sequenceMode int
+
+const (
+	// modeInOrder requires the expected entries to appear in order, with
+	// other unmatched entries allowed in between.
+	modeInOrder sequenceMode = iota
+	// modeConsecutive requires the expected entries to appear in order
+	// with no unmatched entries in between.
+	modeConsecutive
+	// modeAnyOrder requires every expected entry to match some distinct
+	// actual entry, regardless of order.
+	modeAnyOrder
+)
+
 type logMatcher struct {
 	actual   logEntries
 	expected logEntries
+	mode     sequenceMode
 }
 
 func ContainSequence(expectedSequence ...logEntry) types.GomegaMatcher {
@@ -47,6 +141,27 @@ func ContainSequence(expectedSequence ...logEntry) types.GomegaMatcher {
 	}
 }
 
+func ContainInOrder(expectedSequence ...logEntry) types.GomegaMatcher {
+	return &logMatcher{
+		expected: expectedSequence,
+		mode:     modeInOrder,
+	}
+}
+
+func ContainConsecutiveSequence(expectedSequence ...logEntry) types.GomegaMatcher {
+	return &logMatcher{
+		expected: expectedSequence,
+		mode:     modeConsecutive,
+	}
+}
+
+func ContainEntriesInAnyOrder(expectedEntries ...logEntry) types.GomegaMatcher {
+	return &logMatcher{
+		expected: expectedEntries,
+		mode:     modeAnyOrder,
+	}
+}
+
 func Info(options ...option) logEntry {
 	return Entry(lager.INFO, options...)
 }
@@ -74,10 +189,12 @@ func Fatal(err error, options ...option) logEntry {
 }
 
 func Entry(logLevel lager.LogLevel, options ...option) logEntry {
-	entry := logEntry(lager.LogFormat{
-		LogLevel: logLevel,
-		Data:     lager.Data{},
-	})
+	entry := logEntry{
+		LogFormat: lager.LogFormat{
+			LogLevel: logLevel,
+			Data:     lager.Data{},
+		},
+	}
 
 	for _, option := range options {
 		option(&entry)
@@ -88,7 +205,13 @@ func Entry(logLevel lager.LogLevel, options ...option) logEntry {
 
 func Message(msg string) option {
 	return func(e *logEntry) {
-		e.Message = msg
+		e.message = equalMatcher{msg}
+	}
+}
+
+func MatchMessage(pattern string) option {
+	return func(e *logEntry) {
+		e.message = regexpMatcher{regexp.MustCompile(pattern)}
 	}
 }
 
@@ -98,7 +221,13 @@ func Action(action string) option {
 
 func Source(src string) option {
 	return func(e *logEntry) {
-		e.Source = src
+		e.source = equalMatcher{src}
+	}
+}
+
+func MatchSource(pattern string) option {
+	return func(e *logEntry) {
+		e.source = regexpMatcher{regexp.MustCompile(pattern)}
 	}
 }
 
@@ -114,16 +243,69 @@ func Data(kv ...interface{}) option {
 				err := fmt.Errorf("Invalid type for data key. Want string. Got %T:%v.", kv[i], kv[i])
 				panic(err)
 			}
-			e.Data[key] = kv[i+1]
+			e.setDataMatcher(key, equalMatcher{kv[i+1]})
 		}
 	}
 }
 
+func WithoutData(keys ...string) option {
+	return func(e *logEntry) {
+		e.absentDataKeys = append(e.absentDataKeys, keys...)
+	}
+}
+
+func WithoutDataValue(key string, val interface{}) option {
+	return func(e *logEntry) {
+		e.setAbsentDataMatcher(key, equalMatcher{val})
+	}
+}
+
+func (e *logEntry) setAbsentDataMatcher(key string, m fieldMatcher) {
+	if e.absentDataValues == nil {
+		e.absentDataValues = map[string]fieldMatcher{}
+	}
+	e.absentDataValues[key] = m
+}
+
+func MatchData(key string, matcher types.GomegaMatcher) option {
+	return func(e *logEntry) {
+		e.setDataMatcher(key, gomegaMatcher{matcher})
+	}
+}
+
+func TraceID(id string) option {
+	return Data("trace-id", id)
+}
+
+func SpanID(id string) option {
+	return Data("span-id", id)
+}
+
+// Stack asserts that the "trace" data key produced by (lager.Logger).Fatal
+// satisfies matcher, instead of requiring a literal stack trace.
+func Stack(matcher types.GomegaMatcher) option {
+	return MatchData("trace", matcher)
+}
+
+func StackContains(substr string) option {
+	return Stack(gomega.ContainSubstring(substr))
+}
+
+func (e *logEntry) setDataMatcher(key string, m fieldMatcher) {
+	if e.dataMatchers == nil {
+		e.dataMatchers = map[string]fieldMatcher{}
+	}
+	e.dataMatchers[key] = m
+}
+
 type ContentsProvider interface {
 	Contents() []byte
 }
 
-func (lm *logMatcher) Match(actual interface{}) (success bool, err error) {
+// decodeLogEntries reads actual, which must be an io.Reader, a
+// glager.ContentsProvider, or a gbytes.BufferProvider, and decodes its
+// contents as a stream of JSON log entries.
+func decodeLogEntries(actual interface{}) (logEntries, error) {
 	var reader io.Reader
 
 	switch x := actual.(type) {
@@ -134,39 +316,40 @@ func (lm *logMatcher) Match(actual interface{}) (success bool, err error) {
 	case io.Reader:
 		reader = x
 	default:
-		return false, fmt.Errorf("ContainSequence must be passed an io.Reader, glager.ContentsProvider, or gbytes.BufferProvider. Got:\n%s", format.Object(actual, 1))
+		return nil, fmt.Errorf("glager matchers must be passed an io.Reader, glager.ContentsProvider, or gbytes.BufferProvider. Got:\n%s", format.Object(actual, 1))
 	}
 
 	decoder := json.NewDecoder(reader)
 
-	lm.actual = logEntries{}
+	entries := logEntries{}
 
 	for {
 		var entry logEntry
 		if err := decoder.Decode(&entry); err == io.EOF {
 			break
 		} else if err != nil {
-			return false, err
+			return nil, err
 		}
-		lm.actual = append(lm.actual, entry)
+		entries = append(entries, entry)
 	}
 
-	actualEntries := lm.actual
-
-	for _, expected := range lm.expected {
-		i, found, err := actualEntries.indexOf(expected)
-		if err != nil {
-			return false, err
-		}
-
-		if !found {
-			return false, nil
-		}
+	return entries, nil
+}
 
-		actualEntries = actualEntries[i+1:]
+func (lm *logMatcher) Match(actual interface{}) (success bool, err error) {
+	lm.actual, err = decodeLogEntries(actual)
+	if err != nil {
+		return false, err
 	}
 
-	return true, nil
+	switch lm.mode {
+	case modeConsecutive:
+		return lm.actual.containsConsecutive(lm.expected)
+	case modeAnyOrder:
+		return lm.actual.containsInAnyOrder(lm.expected)
+	default:
+		return lm.actual.containsInOrder(lm.expected)
+	}
 }
 
 func (lm *logMatcher) FailureMessage(actual interface{}) (message string) {
@@ -185,53 +368,424 @@ func (lm *logMatcher) NegatedFailureMessage(actual interface{}) (message string)
 	)
 }
 
+// traceMatcher asserts that a set of log entries all carry the same,
+// non-empty trace-id, without the caller needing to know that value.
+type traceMatcher struct {
+	actual   logEntries
+	expected logEntries
+}
+
+func SameTrace(entries ...logEntry) types.GomegaMatcher {
+	return &traceMatcher{
+		expected: entries,
+	}
+}
+
+func (tm *traceMatcher) Match(actual interface{}) (success bool, err error) {
+	tm.actual, err = decodeLogEntries(actual)
+	if err != nil {
+		return false, err
+	}
+
+	assignedTo, found, err := tm.actual.matchDistinct(tm.expected)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		return false, nil
+	}
+
+	traceID := ""
+
+	for _, i := range assignedTo {
+		id, ok := tm.actual[i].Data["trace-id"].(string)
+		if !ok || id == "" {
+			return false, nil
+		}
+
+		if traceID == "" {
+			traceID = id
+		} else if id != traceID {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (tm *traceMatcher) FailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf(
+		"Expected\n\t%s\nto contain entries matching\n\t%s\nall sharing the same trace-id",
+		format.Object(tm.actual, 0),
+		format.Object(tm.expected, 0),
+	)
+}
+
+func (tm *traceMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf(
+		"Expected\n\t%s\nnot to contain entries matching\n\t%s\nall sharing the same trace-id",
+		format.Object(tm.actual, 0),
+		format.Object(tm.expected, 0),
+	)
+}
+
+// readerProvider is implemented by TestLogger, giving eventualMatcher a
+// thread-safe, append-only stream to poll incrementally.
+type readerProvider interface {
+	Reader() io.Reader
+}
+
+func toReader(actual interface{}) (io.Reader, error) {
+	switch x := actual.(type) {
+	case readerProvider:
+		return x.Reader(), nil
+	case io.Reader:
+		return x, nil
+	case gbytes.BufferProvider:
+		return x.Buffer(), nil
+	default:
+		return nil, fmt.Errorf("ContainSequenceEventually must be passed an io.Reader, a glager.TestLogger, or a gbytes.BufferProvider. Got:\n%s", format.Object(actual, 1))
+	}
+}
+
+// eventualMatcher supports polling via gomega's Eventually. It binds to the
+// actual log stream once and, on every subsequent Match call, drains and
+// decodes only the bytes that have newly arrived since the previous poll,
+// carrying over any trailing, not-yet-complete JSON value. It also
+// remembers how much of the expected sequence it has already matched, so
+// that a sequence spread across multiple log flushes is still recognized.
+type eventualMatcher struct {
+	expected logEntries
+	actual   logEntries
+	reader   io.Reader
+	pending  []byte
+	matched  int
+}
+
+func ContainSequenceEventually(expectedSequence ...logEntry) types.GomegaMatcher {
+	return &eventualMatcher{
+		expected: expectedSequence,
+	}
+}
+
+func (em *eventualMatcher) Match(actual interface{}) (success bool, err error) {
+	if em.reader == nil {
+		em.reader, err = toReader(actual)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	chunk := make([]byte, 4096)
+	for {
+		n, err := em.reader.Read(chunk)
+		if n > 0 {
+			em.pending = append(em.pending, chunk[:n]...)
+		}
+		if n == 0 || err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(em.pending))
+	var consumed int64
+
+	for {
+		var entry logEntry
+		if err := decoder.Decode(&entry); err != nil {
+			// io.EOF means we've drained every complete entry; any other
+			// error means the trailing bytes are an incomplete JSON value
+			// that will be completed by a later poll.
+			break
+		}
+
+		consumed = decoder.InputOffset()
+		em.actual = append(em.actual, entry)
+
+		ok, err := entry.contains(em.expected[em.matched])
+		if err != nil {
+			return false, err
+		}
+
+		if ok {
+			em.matched++
+			if em.matched == len(em.expected) {
+				return true, nil
+			}
+		}
+	}
+
+	em.pending = em.pending[consumed:]
+
+	return false, nil
+}
+
+func (em *eventualMatcher) FailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf(
+		"Expected\n\t%s\nto eventually contain log sequence \n\t%s",
+		format.Object(em.actual, 0),
+		format.Object(em.expected, 0),
+	)
+}
+
+func (em *eventualMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf(
+		"Expected\n\t%s\nnot to eventually contain log sequence \n\t%s",
+		format.Object(em.actual, 0),
+		format.Object(em.expected, 0),
+	)
+}
+
+// notContainMatcher succeeds when no actual entry matches any of expected,
+// unlike ToNot(ContainSequence(expected...)) which only requires the full
+// sequence to be absent rather than every single entry.
+type notContainMatcher struct {
+	actual   logEntries
+	expected logEntries
+}
+
+func NotContainEntry(expected ...logEntry) types.GomegaMatcher {
+	return &notContainMatcher{
+		expected: expected,
+	}
+}
+
+func (ncm *notContainMatcher) Match(actual interface{}) (success bool, err error) {
+	ncm.actual, err = decodeLogEntries(actual)
+	if err != nil {
+		return false, err
+	}
+
+	for _, expected := range ncm.expected {
+		_, found, err := ncm.actual.indexOf(expected)
+		if err != nil {
+			return false, err
+		}
+
+		if found {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (ncm *notContainMatcher) FailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf(
+		"Expected\n\t%s\nnot to contain an entry matching\n\t%s",
+		format.Object(ncm.actual, 0),
+		format.Object(ncm.expected, 0),
+	)
+}
+
+func (ncm *notContainMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf(
+		"Expected\n\t%s\nto contain an entry matching\n\t%s",
+		format.Object(ncm.actual, 0),
+		format.Object(ncm.expected, 0),
+	)
+}
+
 func (entry logEntry) logData() logEntryData {
 	return logEntryData(entry.Data)
 }
 
 func (actual logEntry) contains(expected logEntry) (bool, error) {
-	if expected.Source != "" && actual.Source != expected.Source {
-		return false, nil
+	if expected.source != nil {
+		ok, err := expected.source.match(actual.Source)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, nil
+		}
 	}
 
-	if expected.Message != "" && actual.Message != expected.Message {
-		return false, nil
+	if expected.message != nil {
+		ok, err := expected.message.match(actual.Message)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, nil
+		}
 	}
 
 	if actual.LogLevel != expected.LogLevel {
 		return false, nil
 	}
 
-	containsData, err := actual.logData().contains(expected.logData())
+	containsData, err := actual.logData().contains(expected.dataMatchers)
 	if err != nil {
 		return false, err
 	}
+	if !containsData {
+		return false, nil
+	}
+
+	for _, key := range expected.absentDataKeys {
+		if _, found := actual.Data[key]; found {
+			return false, nil
+		}
+	}
+
+	for key, matcher := range expected.absentDataValues {
+		actualVal, found := actual.Data[key]
+		if !found {
+			continue
+		}
+
+		ok, err := matcher.match(actualVal)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return false, nil
+		}
+	}
 
-	return containsData, nil
+	return true, nil
 }
 
-func (actual logEntryData) contains(expected logEntryData) (bool, error) {
-	for expectedKey, expectedVal := range expected {
+func (actual logEntryData) contains(expected map[string]fieldMatcher) (bool, error) {
+	for expectedKey, matcher := range expected {
 		actualVal, found := actual[expectedKey]
 		if !found {
 			return false, nil
 		}
 
-		// this has been marshalled and unmarshalled before, no need to check err
-		actualJSON, _ := json.Marshal(actualVal)
+		ok, err := matcher.match(actualVal)
+		if err != nil {
+			return false, err
+		}
+
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
 
-		expectedJSON, err := json.Marshal(expectedVal)
+func (entries logEntries) containsInOrder(expected logEntries) (bool, error) {
+	actualEntries := entries
+
+	for _, expectedEntry := range expected {
+		i, found, err := actualEntries.indexOf(expectedEntry)
 		if err != nil {
 			return false, err
 		}
 
-		if string(actualJSON) != string(expectedJSON) {
+		if !found {
 			return false, nil
 		}
+
+		actualEntries = actualEntries[i+1:]
 	}
+
 	return true, nil
 }
 
+func (entries logEntries) containsConsecutive(expected logEntries) (bool, error) {
+	for i := 0; i+len(expected) <= len(entries); i++ {
+		matches := true
+
+		for k, expectedEntry := range expected {
+			ok, err := entries[i+k].contains(expectedEntry)
+			if err != nil {
+				return false, err
+			}
+
+			if !ok {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (entries logEntries) containsInAnyOrder(expected logEntries) (bool, error) {
+	_, ok, err := entries.matchDistinct(expected)
+	return ok, err
+}
+
+// matchDistinct finds an assignment of each expected entry to a distinct
+// actual entry, regardless of order, using the standard augmenting-path
+// algorithm for bipartite matching. On success, assignedTo[e] holds the
+// index into entries matched to expected[e].
+func (entries logEntries) matchDistinct(expected logEntries) (assignedTo []int, success bool, err error) {
+	assignedTo = make([]int, len(expected))
+	for i := range assignedTo {
+		assignedTo[i] = -1
+	}
+
+	matchedBy := make([]int, len(entries))
+	for i := range matchedBy {
+		matchedBy[i] = -1
+	}
+
+	var assign func(e int, visited []bool) (bool, error)
+	assign = func(e int, visited []bool) (bool, error) {
+		for a, actualEntry := range entries {
+			if visited[a] {
+				continue
+			}
+
+			ok, err := actualEntry.contains(expected[e])
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				continue
+			}
+
+			visited[a] = true
+
+			if matchedBy[a] == -1 {
+				matchedBy[a] = e
+				return true, nil
+			}
+
+			reassigned, err := assign(matchedBy[a], visited)
+			if err != nil {
+				return false, err
+			}
+			if reassigned {
+				matchedBy[a] = e
+				return true, nil
+			}
+		}
+
+		return false, nil
+	}
+
+	for e := range expected {
+		ok, err := assign(e, make([]bool, len(entries)))
+		if err != nil {
+			return nil, false, err
+		}
+		if !ok {
+			return nil, false, nil
+		}
+	}
+
+	for a, e := range matchedBy {
+		if e != -1 {
+			assignedTo[e] = a
+		}
+	}
+
+	return assignedTo, true, nil
+}
+
 func (entries logEntries) indexOf(entry logEntry) (int, bool, error) {
 	for i, actual := range entries {
 		containsEntry, err := actual.contains(entry)
@@ -4,13 +4,16 @@ import (
 	"bufio"
 	"errors"
 	"io"
+	"net/http"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gbytes"
-	"github.com/pivotal-golang/lager"
-	"github.com/pivotal-golang/lager/lagertest"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+
 	. "github.com/st3v/glager"
 )
 
@@ -166,11 +169,258 @@ var _ = Describe(".ContainSequence", func() {
 			It("does not match a fatal entry", func() {
 				Expect(log).ToNot(ContainSequence(
 					Fatal(
+						AnyErr,
 						Source("logger"),
 						Data("event", "failed", "task", "my-task"),
 					),
 				))
 			})
+
+			It("matches a message against a regexp", func() {
+				Expect(log).To(ContainSequence(
+					Info(MatchMessage("logger\\.action")),
+				))
+			})
+
+			It("does not match a message against a non-matching regexp", func() {
+				Expect(log).ToNot(ContainSequence(
+					Info(MatchMessage("logger\\.other")),
+				))
+			})
+
+			It("matches a source against a regexp", func() {
+				Expect(log).To(ContainSequence(
+					Info(MatchSource("log.*")),
+				))
+			})
+
+			It("matches data against a gomega matcher", func() {
+				Expect(log).To(ContainSequence(
+					Info(MatchData("task", HavePrefix("my-"))),
+				))
+			})
+
+			It("does not match data against a failing gomega matcher", func() {
+				Expect(log).ToNot(ContainSequence(
+					Info(MatchData("task", HavePrefix("not-"))),
+				))
+			})
+
+			It("matches a gap-tolerant sequence via ContainInOrder", func() {
+				Expect(log).To(ContainInOrder(
+					Info(Data("event", "starting", "task", "my-task")),
+					Error(expectedError, Data("event", "failed", "task", "my-task")),
+				))
+			})
+
+			It("matches a consecutive sequence via ContainConsecutiveSequence", func() {
+				Expect(log).To(ContainConsecutiveSequence(
+					Info(Data("event", "starting", "task", "my-task")),
+					Debug(Data("event", "debugging", "task", "my-task")),
+				))
+			})
+
+			It("does not match a non-consecutive sequence via ContainConsecutiveSequence", func() {
+				Expect(log).ToNot(ContainConsecutiveSequence(
+					Info(Data("event", "starting", "task", "my-task")),
+					Error(expectedError, Data("event", "failed", "task", "my-task")),
+				))
+			})
+
+			It("matches entries regardless of order via ContainEntriesInAnyOrder", func() {
+				Expect(log).To(ContainEntriesInAnyOrder(
+					Error(expectedError, Data("event", "failed", "task", "my-task")),
+					Info(Data("event", "starting", "task", "my-task")),
+				))
+			})
+
+			It("does not match the same actual entry twice via ContainEntriesInAnyOrder", func() {
+				Expect(log).ToNot(ContainEntriesInAnyOrder(
+					Info(Data("task", "my-task")),
+					Info(Data("task", "my-task")),
+					Info(Data("task", "my-task")),
+				))
+			})
+		})
+	})
+})
+
+var _ = Describe(".SameTrace", func() {
+	var (
+		buffer *gbytes.Buffer
+		logger lager.Logger
+	)
+
+	BeforeEach(func() {
+		buffer = gbytes.NewBuffer()
+		logger = lager.NewLogger("logger")
+		logger.RegisterSink(lager.NewWriterSink(buffer, lager.DEBUG))
+
+		req, err := http.NewRequest("GET", "http://example.com", nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("X-Vcap-Request-Id", "dead-beef-dead-beef")
+
+		traced := logger.WithTraceInfo(req)
+		traced.Info("starting", lager.Data{"task": "my-task"})
+		traced.Info("finished", lager.Data{"task": "my-task"})
+	})
+
+	It("matches entries sharing the same trace-id", func() {
+		Expect(buffer).To(SameTrace(
+			Info(Action("logger.starting")),
+			Info(Action("logger.finished")),
+		))
+	})
+
+	It("does not match an entry outside the trace", func() {
+		logger.Info("untraced")
+
+		Expect(buffer).ToNot(SameTrace(
+			Info(Action("logger.starting")),
+			Info(Action("logger.untraced")),
+		))
+	})
+
+	It("exposes the trace-id injected by WithTraceInfo as matchable data", func() {
+		Expect(buffer).To(ContainSequence(
+			Info(Action("logger.starting"), MatchData("trace-id", Not(BeEmpty()))),
+		))
+	})
+
+	It("does not let a single entry satisfy more than one expected pattern", func() {
+		singleEntryBuffer := gbytes.NewBuffer()
+		singleEntryLogger := lager.NewLogger("logger")
+		singleEntryLogger.RegisterSink(lager.NewWriterSink(singleEntryBuffer, lager.DEBUG))
+
+		req, err := http.NewRequest("GET", "http://example.com", nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("X-Vcap-Request-Id", "dead-beef-dead-beef")
+
+		singleEntryLogger.WithTraceInfo(req).Info("starting", lager.Data{"task": "my-task"})
+
+		Expect(singleEntryBuffer).ToNot(SameTrace(
+			Info(Action("logger.starting")),
+			Info(Action("logger.starting")),
+		))
+	})
+})
+
+var _ = Describe(".RecoverFatal", func() {
+	var logger *TestLogger
+
+	BeforeEach(func() {
+		logger = NewLogger("logger")
+	})
+
+	It("recovers from the panic raised by Fatal", func() {
+		Expect(func() {
+			RecoverFatal(logger, func() {
+				logger.Fatal("dying", errors.New("boom"))
+			})
+		}).NotTo(Panic())
+	})
+
+	It("returns the buffer contents logged up to the point of the fatal error", func() {
+		output := RecoverFatal(logger, func() {
+			logger.Fatal("dying", errors.New("boom"))
 		})
+
+		Expect(output).To(ContainSequence(
+			Fatal(errors.New("boom"), Action("logger.dying")),
+		))
+	})
+
+	It("captures the stack trace under the trace data key", func() {
+		output := RecoverFatal(logger, func() {
+			logger.Fatal("dying", errors.New("boom"))
+		})
+
+		Expect(output).To(ContainSequence(
+			Fatal(errors.New("boom"), StackContains("glager_test")),
+		))
+	})
+})
+
+var _ = Describe(".ContainSequenceEventually", func() {
+	var logger *TestLogger
+
+	BeforeEach(func() {
+		logger = NewLogger("logger")
+	})
+
+	It("matches a sequence that arrives across multiple flushes", func() {
+		matcher := ContainSequenceEventually(
+			Info(Action("logger.starting")),
+			Info(Action("logger.finished")),
+		)
+
+		logger.Info("starting")
+
+		go func() {
+			logger.Info("finished")
+		}()
+
+		Eventually(logger).Should(matcher)
+	})
+
+	It("does not match a sequence that never arrives", func() {
+		matcher := ContainSequenceEventually(
+			Info(Action("logger.never-happens")),
+		)
+
+		logger.Info("starting")
+
+		Consistently(logger).ShouldNot(matcher)
+	})
+})
+
+var _ = Describe(".NotContainEntry", func() {
+	var (
+		buffer *gbytes.Buffer
+		logger lager.Logger
+	)
+
+	BeforeEach(func() {
+		buffer = gbytes.NewBuffer()
+		logger = lager.NewLogger("logger")
+		logger.RegisterSink(lager.NewWriterSink(buffer, lager.DEBUG))
+
+		logger.Info("redacted", lager.Data{"authorization": "[REDACTED]"})
+	})
+
+	It("succeeds when no entry matches the given spec", func() {
+		Expect(buffer).To(NotContainEntry(
+			Info(Action("logger.redacted"), Data("authorization", "Bearer secret")),
+		))
+	})
+
+	It("fails when an entry matches the given spec", func() {
+		Expect(buffer).ToNot(NotContainEntry(
+			Info(Action("logger.redacted"), Data("authorization", "[REDACTED]")),
+		))
+	})
+
+	It("succeeds when the offending key is absent via WithoutData", func() {
+		Expect(buffer).To(ContainSequence(
+			Info(Action("logger.redacted"), WithoutData("password")),
+		))
+	})
+
+	It("fails when the offending key is present via WithoutData", func() {
+		Expect(buffer).ToNot(ContainSequence(
+			Info(Action("logger.redacted"), WithoutData("authorization")),
+		))
+	})
+
+	It("succeeds when the key does not hold the offending value via WithoutDataValue", func() {
+		Expect(buffer).To(ContainSequence(
+			Info(Action("logger.redacted"), WithoutDataValue("authorization", "Bearer secret")),
+		))
+	})
+
+	It("fails when the key holds the offending value via WithoutDataValue", func() {
+		Expect(buffer).ToNot(ContainSequence(
+			Info(Action("logger.redacted"), WithoutDataValue("authorization", "[REDACTED]")),
+		))
 	})
 })